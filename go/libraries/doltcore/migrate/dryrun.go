@@ -0,0 +1,107 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressCadence is how often, in commits, a dry run emits a progress
+// event through Progress.Log.
+const progressCadence = 25
+
+// DryRunStats accumulates the statistics a dry run gathers while walking the
+// same diff streams a real migration would, without writing anything to the
+// new DoltDB. It is safe for concurrent use by migrateGraph's worker pool.
+type DryRunStats struct {
+	started time.Time
+
+	CommitsVisited     int64
+	TablesTouched      int64
+	RowsTranslated     int64
+	ApproxBytes        int64
+	PatchedSchemas     int64
+	UnsupportedSchemas int64
+
+	// total, set once via SetTotal, is the number of commits this run
+	// expects to visit. logProgress uses it to report an ETA and a
+	// remaining count instead of just a raw rate.
+	total int64
+
+	mu sync.Mutex
+}
+
+// NewDryRunStats returns an empty DryRunStats with its clock started, for
+// computing an ETA as commits are visited.
+func NewDryRunStats() *DryRunStats {
+	return &DryRunStats{started: time.Now()}
+}
+
+func (s *DryRunStats) addRow(tupleBytes int) {
+	atomic.AddInt64(&s.RowsTranslated, 1)
+	atomic.AddInt64(&s.ApproxBytes, int64(tupleBytes))
+}
+
+func (s *DryRunStats) addTable(patchedSchema, unsupportedSchema bool) {
+	atomic.AddInt64(&s.TablesTouched, 1)
+	if patchedSchema {
+		atomic.AddInt64(&s.PatchedSchemas, 1)
+	}
+	if unsupportedSchema {
+		atomic.AddInt64(&s.UnsupportedSchemas, 1)
+	}
+}
+
+// visitCommit records that a commit was visited, and reports whether this
+// visit falls on the progress cadence so the caller should emit a log event.
+func (s *DryRunStats) visitCommit() (shouldLog bool) {
+	n := atomic.AddInt64(&s.CommitsVisited, 1)
+	return n%progressCadence == 0
+}
+
+// SetTotal records the number of commits this run expects to visit, letting
+// logProgress report an ETA and a remaining count instead of just a rate.
+// graphScheduler.run calls this once, after computing how many commits in
+// the graph still need migration.
+func (s *DryRunStats) SetTotal(totalCommits int) {
+	atomic.StoreInt64(&s.total, int64(totalCommits))
+}
+
+// logProgress emits a single progress event summarizing throughput and, if
+// SetTotal has been called, estimated time remaining.
+func (s *DryRunStats) logProgress(ctx context.Context, prog Progress) {
+	visited := atomic.LoadInt64(&s.CommitsVisited)
+	total := atomic.LoadInt64(&s.total)
+	elapsed := time.Since(s.started).Seconds()
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+	rate := float64(visited) / elapsed
+
+	if total <= 0 || rate <= 0 {
+		prog.Log(ctx, "dry run: %d commits visited (%.1f commits/sec)", visited, rate)
+		return
+	}
+
+	remaining := total - visited
+	if remaining < 0 {
+		remaining = 0
+	}
+	eta := time.Duration(float64(remaining)/rate) * time.Second
+	prog.Log(ctx, "dry run: %d/%d commits visited (%.1f commits/sec, ETA %s)", visited, total, rate, eta.Round(time.Second))
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// loggingProgress is a minimal Progress that only needs to capture what
+// DryRunStats.logProgress logs.
+type loggingProgress struct {
+	*inMemoryProgress
+	lines []string
+}
+
+func (p *loggingProgress) Log(ctx context.Context, msg string, args ...interface{}) {
+	p.lines = append(p.lines, msg)
+}
+
+// TestLogProgressNeedsSetTotal reproduces the bug where the only call site
+// passed a hard-coded totalCommits of 0: logProgress only computes an
+// ETA/remaining count when it's told a total, so every dry run only ever
+// logged the no-ETA branch. SetTotal is what graphScheduler.run now calls to
+// fix that.
+func TestLogProgressNeedsSetTotal(t *testing.T) {
+	prog := &loggingProgress{inMemoryProgress: newInMemoryProgress()}
+
+	stats := NewDryRunStats()
+	stats.visitCommit()
+	stats.logProgress(context.Background(), prog)
+
+	if len(prog.lines) != 1 {
+		t.Fatalf("expected one log line, got %d", len(prog.lines))
+	}
+	if strings.Contains(prog.lines[0], "ETA") {
+		t.Fatalf("expected no ETA without a total set, got %q", prog.lines[0])
+	}
+
+	stats.SetTotal(10)
+	stats.logProgress(context.Background(), prog)
+
+	if len(prog.lines) != 2 {
+		t.Fatalf("expected two log lines, got %d", len(prog.lines))
+	}
+	if !strings.Contains(prog.lines[1], "ETA") {
+		t.Fatalf("expected an ETA once a total is set, got %q", prog.lines[1])
+	}
+}
@@ -0,0 +1,576 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/doltdb"
+	"github.com/dolthub/dolt/go/libraries/doltcore/ref"
+	"github.com/dolthub/dolt/go/store/hash"
+)
+
+// GraphOptions configures migrateGraph.
+type GraphOptions struct {
+	// Jobs is the number of commits that may be migrated concurrently.
+	Jobs int
+	// FlushEvery batches the ChunkStore flush: flushRef is only updated
+	// once every FlushEvery commits that migrateCommit reports as needing a
+	// flush, rather than after each one.
+	FlushEvery int
+	// Level is the ValidationLevel run against each migrated commit. It is
+	// only honored when LevelSet is true; withDefaults can't otherwise tell
+	// an explicit `--validate=none` apart from a GraphOptions whose caller
+	// never touched Level at all, since ValidateNone is also Level's zero
+	// value.
+	Level ValidationLevel
+	// LevelSet marks that Level was deliberately chosen, including an
+	// explicit ValidateNone. Leave it false to get the ValidateRows default.
+	LevelSet bool
+	// Report, if non-nil, accumulates a ValidationResult for every
+	// Validator run against every table in every migrated commit.
+	Report *ValidationReport
+	// DryRun, if set, migrates no data: migrateCommit traverses the same
+	// diff streams and translates tuples, but discards the result and
+	// writes nothing to |new|.
+	DryRun bool
+	// Stats, if DryRun is set, accumulates the statistics gathered while
+	// walking the commit graph.
+	Stats *DryRunStats
+	// Registry, if non-nil, is consulted for a TableMigrator before a
+	// table falls back to the default translation path.
+	Registry *TableMigratorRegistry
+	// Shallow, if non-nil, bounds how much history buildCommitGraph walks
+	// per branch. Commits older than the bound are never visited; the
+	// oldest commit kept is migrated as a synthetic graft root instead of
+	// against its real (unvisited) parent.
+	Shallow *ShallowOptions
+}
+
+// ShallowOptions bounds how much history a migration walks per branch, for
+// repositories where migrating the full history is impractical.
+type ShallowOptions struct {
+	// Depth, if positive, keeps only the last Depth commits reachable from
+	// each branch tip, inclusive of the tip itself.
+	Depth int
+	// Since, if non-zero, stops the walk at this commit: it becomes the
+	// graft root for any branch it is an ancestor of. Since and Depth may
+	// be combined with each other and with SinceTime; whichever bound is
+	// reached first wins.
+	Since hash.Hash
+	// SinceTime, if non-zero, stops the walk at the first commit committed
+	// at or before this time: that commit becomes the graft root for any
+	// branch it is an ancestor of. This is the bound behind `migrate --since
+	// <timestamp>`, as opposed to `--since <commit>`, which sets Since.
+	SinceTime time.Time
+}
+
+// cutoff reports whether the commit |h|, reached at |depth| commits from its
+// nearest branch tip and committed at |committedAt|, is the oldest commit
+// this migration should keep. |committedAt| is the zero time.Time when
+// SinceTime is unset, since buildCommitGraph skips loading commit metadata
+// it doesn't need.
+func (s *ShallowOptions) cutoff(h hash.Hash, depth int, committedAt time.Time) bool {
+	if s.Since != (hash.Hash{}) && h == s.Since {
+		return true
+	}
+	if !s.SinceTime.IsZero() && !committedAt.After(s.SinceTime) {
+		return true
+	}
+	return s.Depth > 0 && depth >= s.Depth-1
+}
+
+func (o GraphOptions) withDefaults() GraphOptions {
+	if o.Jobs <= 0 {
+		o.Jobs = 1
+	}
+	if o.FlushEvery <= 0 {
+		o.FlushEvery = 1
+	}
+	if !o.LevelSet {
+		// the default stays rows for safety: a caller that never sets Level
+		// still gets post-migration validation, not silently none. Guarding
+		// on LevelSet (rather than "Level == ValidateNone") is what lets a
+		// caller actually select --validate=none instead of having it
+		// promoted back to rows every time.
+		o.Level = ValidateRows
+		o.LevelSet = true
+	}
+	return o
+}
+
+// commitNode is a single vertex in the in-memory commit graph built by
+// buildCommitGraph.
+type commitNode struct {
+	cm       *doltdb.Commit
+	hash     hash.Hash
+	parents  []hash.Hash
+	children []hash.Hash
+	// graft is set when opts.Shallow bounded the walk at this commit: it
+	// has no parents for the purposes of this migration, and is migrated
+	// as a synthetic graft root rather than diffed against a real parent.
+	graft bool
+}
+
+// migrateGraph migrates every commit reachable from |branches| onto |new|,
+// dispatching commits whose parents have already been migrated onto a
+// worker pool of size opts.Jobs. It replaces the purely serial, per-branch
+// walk driven by repeated calls to migrateCommit with a scheduler that
+// exploits parallelism across independent lines of history.
+func migrateGraph(ctx context.Context, old, new *doltdb.DoltDB, branches []ref.BranchRef, prog Progress, opts GraphOptions) error {
+	opts = opts.withDefaults()
+
+	nodes, order, err := buildCommitGraph(ctx, old, branches, opts.Shallow)
+	if err != nil {
+		return err
+	}
+
+	sched := &graphScheduler{
+		old:     old,
+		new:     new,
+		prog:    prog,
+		opts:    opts,
+		nodes:   nodes,
+		ready:   make(chan hash.Hash, len(order)),
+		pending: make(map[hash.Hash]int, len(order)),
+	}
+
+	return sched.run(ctx, order)
+}
+
+// queuedCommit is a commit discovered by buildCommitGraph's breadth-first
+// walk, paired with its depth from the nearest branch tip that reaches it.
+type queuedCommit struct {
+	cm    *doltdb.Commit
+	depth int
+}
+
+// buildCommitGraph walks the DAG reachable from |branches| exactly once,
+// returning every node keyed by hash and a topological order (parents before
+// children) to seed the scheduler with. If |shallow| is non-nil, the walk
+// stops at each branch's cutoff commit instead of reaching the true roots,
+// and that commit is flagged as a graft point.
+//
+// The walk is breadth-first, by depth, rather than a per-branch depth-first
+// recursion: a commit reachable from two branches at different depths must
+// have its cutoff decided by the shallower branch's requirement, not by
+// whichever branch's DFS happened to reach it first. BFS guarantees the
+// first time a commit is dequeued is via its shortest path from any tip, so
+// its graft decision is made exactly once, correctly, regardless of visit
+// order.
+func buildCommitGraph(ctx context.Context, old *doltdb.DoltDB, branches []ref.BranchRef, shallow *ShallowOptions) (map[hash.Hash]*commitNode, []hash.Hash, error) {
+	nodes := make(map[hash.Hash]*commitNode)
+
+	var queue []queuedCommit
+	for _, br := range branches {
+		cm, err := old.ResolveCommitRef(ctx, br)
+		if err != nil {
+			return nil, nil, err
+		}
+		queue = append(queue, queuedCommit{cm: cm, depth: 0})
+	}
+
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		h, err := next.cm.HashOf()
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := nodes[h]; ok {
+			continue // already reached at an equal or shallower depth
+		}
+
+		n := &commitNode{cm: next.cm, hash: h}
+		nodes[h] = n // insert before queueing parents to guard against malformed cycles
+
+		if shallow != nil {
+			var committedAt time.Time
+			if !shallow.SinceTime.IsZero() {
+				meta, err := next.cm.GetCommitMeta(ctx)
+				if err != nil {
+					return nil, nil, err
+				}
+				committedAt = meta.Time()
+			}
+			if shallow.cutoff(h, next.depth, committedAt) {
+				n.graft = true
+				continue // history beyond the cutoff is never visited
+			}
+		}
+
+		for i := 0; i < next.cm.NumParents(); i++ {
+			pcm, err := next.cm.GetParent(ctx, i)
+			if err != nil {
+				return nil, nil, err
+			}
+			ph, err := pcm.HashOf()
+			if err != nil {
+				return nil, nil, err
+			}
+			n.parents = append(n.parents, ph)
+			queue = append(queue, queuedCommit{cm: pcm, depth: next.depth + 1})
+		}
+	}
+
+	// children edges can only be wired up once every node's final parent
+	// list is known, which BFS doesn't guarantee until the queue drains.
+	for h, n := range nodes {
+		for _, ph := range n.parents {
+			nodes[ph].children = append(nodes[ph].children, h)
+		}
+	}
+
+	order, err := topoSortCommits(nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return nodes, order, nil
+}
+
+// topoSortCommits orders |nodes| parents-before-children via Kahn's
+// algorithm, using the parent/child edges buildCommitGraph has already
+// wired up.
+func topoSortCommits(nodes map[hash.Hash]*commitNode) ([]hash.Hash, error) {
+	indegree := make(map[hash.Hash]int, len(nodes))
+	var ready []hash.Hash
+	for h, n := range nodes {
+		indegree[h] = len(n.parents)
+		if len(n.parents) == 0 {
+			ready = append(ready, h)
+		}
+	}
+
+	order := make([]hash.Hash, 0, len(nodes))
+	for len(ready) > 0 {
+		h := ready[0]
+		ready = ready[1:]
+		order = append(order, h)
+
+		for _, c := range nodes[h].children {
+			indegree[c]--
+			if indegree[c] == 0 {
+				ready = append(ready, c)
+			}
+		}
+	}
+
+	if len(order) != len(nodes) {
+		return nil, fmt.Errorf("migrate: commit graph contains a cycle")
+	}
+	return order, nil
+}
+
+// graphScheduler dispatches commitNodes onto a bounded worker pool, only
+// once every parent is visible via prog.Get, and batches the flushRef
+// SetHead call every opts.FlushEvery successful commits. migrateCommit
+// itself never touches flushRef; the scheduler owns every SetHead call so
+// that batching actually happens instead of being overridden by a
+// per-commit flush underneath it.
+type graphScheduler struct {
+	old, new *doltdb.DoltDB
+	prog     Progress
+	opts     GraphOptions
+	nodes    map[hash.Hash]*commitNode
+
+	mu           sync.Mutex // serializes access to pending/flushed counters and pendingFlush
+	pending      map[hash.Hash]int
+	redo         map[hash.Hash]bool // old hash -> must be re-migrated even though prog.Has is already true
+	ready        chan hash.Hash
+	flushed      int
+	pendingFlush []flushEntry // commits migrated since the last successful flush
+
+	flushMu sync.Mutex // serializes flush's SetHead call and bookkeeping against concurrent flushes
+}
+
+// flushEntry records that |old| was migrated and needs its in-flight
+// bookkeeping cleared once the batch covering it actually flushes.
+type flushEntry struct {
+	old   hash.Hash
+	graft bool
+}
+
+func (s *graphScheduler) run(ctx context.Context, order []hash.Hash) error {
+	redo, err := s.computeRedo(ctx, order)
+	if err != nil {
+		return err
+	}
+	s.redo = redo
+
+	// seed ref-counts and the ready queue with commits that have no
+	// unmigrated parent
+	remaining := 0
+	for _, h := range order {
+		n := s.nodes[h]
+		needed, err := s.needsMigration(ctx, h)
+		if err != nil {
+			return err
+		}
+		if needed {
+			remaining++
+		}
+
+		count := 0
+		for _, p := range n.parents {
+			pNeeded, err := s.needsMigration(ctx, p)
+			if err != nil {
+				return err
+			}
+			if pNeeded {
+				count++
+			}
+		}
+		s.pending[h] = count
+		if count == 0 && needed {
+			s.ready <- h
+		}
+	}
+
+	if s.opts.DryRun && s.opts.Stats != nil {
+		s.opts.Stats.SetTotal(remaining)
+	}
+
+	if remaining == 0 {
+		close(s.ready)
+		return nil
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	var remainingMu sync.Mutex
+
+	for i := 0; i < s.opts.Jobs; i++ {
+		eg.Go(func() error {
+			for h := range s.ready {
+				if err := s.migrateOne(ctx, h); err != nil {
+					return err
+				}
+
+				remainingMu.Lock()
+				remaining--
+				done := remaining == 0
+				remainingMu.Unlock()
+				if done {
+					close(s.ready)
+					return nil
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+
+	// flush whatever's left in the final, possibly partial, batch: without
+	// this, a clean run whose commit count isn't a multiple of FlushEvery
+	// would exit with its last few commits still carrying in-flight
+	// markers, making a later Resume redo work that actually finished.
+	return s.flush(ctx)
+}
+
+// computeRedo walks |order| (parents before children) and marks every
+// commit that must be re-migrated even though prog.Has already reports it
+// as done: either needsForcedRedo says so directly, or one of its parents
+// does, in which case its own mapping was built on top of a now-stale
+// parent and must be rebuilt to match.
+func (s *graphScheduler) computeRedo(ctx context.Context, order []hash.Hash) (map[hash.Hash]bool, error) {
+	redo := make(map[hash.Hash]bool, len(order))
+	for _, h := range order {
+		n := s.nodes[h]
+
+		forced, err := needsForcedRedo(ctx, s.prog, h, n.graft)
+		if err != nil {
+			return nil, err
+		}
+
+		r := forced
+		for _, p := range n.parents {
+			if redo[p] {
+				r = true
+				break
+			}
+		}
+		redo[h] = r
+	}
+	return redo, nil
+}
+
+// needsMigration reports whether |h| still has migration work to do this
+// run: either it was never migrated, or computeRedo marked it for
+// re-migration.
+func (s *graphScheduler) needsMigration(ctx context.Context, h hash.Hash) (bool, error) {
+	if s.redo[h] {
+		return true, nil
+	}
+	ok, err := s.prog.Has(ctx, h)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+// migrateOne migrates the commit for |h|, then unblocks any child whose
+// last unmigrated parent was |h|.
+func (s *graphScheduler) migrateOne(ctx context.Context, h hash.Hash) error {
+	n := s.nodes[h]
+
+	needsFlush, err := migrateCommit(ctx, n.cm, s.new, s.prog, s.opts.Level, s.opts.Report, s.opts.DryRun, s.opts.Stats, s.opts.Registry, n.graft, s.redo[h])
+	if err != nil {
+		return err
+	}
+
+	if needsFlush {
+		if err := s.maybeFlush(ctx, h, n.graft); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range n.children {
+		s.mu.Lock()
+		s.pending[child]--
+		ready := s.pending[child] == 0
+		s.mu.Unlock()
+		if ready {
+			s.ready <- child
+		}
+	}
+
+	return nil
+}
+
+// maybeFlush queues |h| as migrated and batches the flushRef SetHead call:
+// the ChunkStore is only flushed once every opts.FlushEvery commits, rather
+// than after each one. migrateCommit and migrateGraftCommit never call
+// SetHead themselves; this is the only place that does, which is what makes
+// the batching real instead of redundant with a per-commit flush underneath
+// it.
+func (s *graphScheduler) maybeFlush(ctx context.Context, h hash.Hash, graft bool) error {
+	s.mu.Lock()
+	s.pendingFlush = append(s.pendingFlush, flushEntry{old: h, graft: graft})
+	s.flushed++
+	due := s.flushed%s.opts.FlushEvery == 0
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+// flush advances flushRef to durably cover every commit migrated since the
+// last successful flush, then clears their in-flight (and graft)
+// bookkeeping now that it's safe to do so. It is a no-op if nothing has
+// been migrated since the last flush, which is always the case for a dry
+// run.
+//
+// flushMu serializes the whole method against concurrent calls: buildCommitGraph's
+// multi-source BFS lets migrateOne dispatch commits from independent lines
+// of history concurrently, so two flushes can race to SetHead with
+// different target hashes. Without serializing the SetHead call and the
+// bookkeeping loop together, whichever call lands last wins, and a commit
+// whose in-flight marker the other call already cleared can end up no
+// longer reachable from flushRef.
+//
+// A single batch can also span more than one of those independent lines,
+// and flushRef can only point at one commit at a time, so one SetHead
+// can't cover the whole batch. flush instead computes the batch's
+// frontier - the entries that aren't an ancestor of any other entry in the
+// same batch - and issues one SetHead per frontier entry; every other
+// entry is, by construction, an ancestor of some frontier entry and so is
+// covered by its update. Bookkeeping is only cleared once every frontier
+// SetHead has succeeded.
+func (s *graphScheduler) flush(ctx context.Context) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	entries := s.pendingFlush
+	s.pendingFlush = nil
+	s.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	for _, old := range s.flushFrontier(entries) {
+		newHead, err := s.prog.Get(ctx, old)
+		if err != nil {
+			return err
+		}
+		if err := s.new.SetHead(ctx, flushRef, newHead); err != nil {
+			return err
+		}
+	}
+
+	dp, ok := s.prog.(*DurableProgress)
+	if !ok {
+		return nil
+	}
+	for _, e := range entries {
+		if err := dp.ClearInFlight(ctx, e.old); err != nil {
+			return err
+		}
+		if e.graft {
+			if err := dp.RecordGraft(ctx, e.old); err != nil {
+				return err
+			}
+		} else if err := dp.ClearGraft(ctx, e.old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushFrontier returns the old hashes of |entries| that are not an
+// ancestor of any other entry in the same batch, by walking each entry's
+// parents up through s.nodes. Migration preserves commit topology, so
+// ancestry among old hashes mirrors ancestry among their migrated
+// counterparts: anchoring flushRef at a frontier entry's migrated hash
+// durably covers every ancestor entry in the batch too.
+func (s *graphScheduler) flushFrontier(entries []flushEntry) []hash.Hash {
+	hasDescendantInBatch := make(map[hash.Hash]bool, len(entries))
+	for _, e := range entries {
+		var markAncestors func(hash.Hash)
+		markAncestors = func(h hash.Hash) {
+			n := s.nodes[h]
+			if n == nil {
+				return
+			}
+			for _, p := range n.parents {
+				if !hasDescendantInBatch[p] {
+					hasDescendantInBatch[p] = true
+					markAncestors(p)
+				}
+			}
+		}
+		markAncestors(e.old)
+	}
+
+	frontier := make([]hash.Hash, 0, len(entries))
+	for _, e := range entries {
+		if !hasDescendantInBatch[e.old] {
+			frontier = append(frontier, e.old)
+		}
+	}
+	return frontier
+}
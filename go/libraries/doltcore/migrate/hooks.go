@@ -0,0 +1,91 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/store/types"
+	"github.com/dolthub/dolt/go/store/val"
+)
+
+// TableMigrator lets a downstream user repair historical data irregularities
+// (wrong collations, enum renames, JSON reshaping, dropped columns) as part
+// of the format migration, rather than as an after-the-fact schema change.
+// It is consulted by migrateRoot and migrateTable in place of the default
+// translation path for any table registered in a TableMigratorRegistry.
+type TableMigrator interface {
+	// PatchSchema rewrites |old| into the schema the migrated table should
+	// have. It replaces the default patchMigrateSchema behavior for
+	// registered tables, and runs for any registered table, not only dolt_
+	// tables.
+	PatchSchema(old schema.Schema) (schema.Schema, error)
+
+	// TranslateRow converts a single row, given as its pre-migration noms
+	// key and value tuples, into its migrated prolly key and value tuples.
+	// It replaces the default tupleTranslatorsFromSchema path for
+	// registered tables' primary row data.
+	TranslateRow(ctx context.Context, oldKey, oldVal types.Tuple, newSch schema.Schema) (val.Tuple, val.Tuple, error)
+}
+
+// TableMigratorRegistry maps table names to the TableMigrator that should
+// handle them, with glob support (as accepted by path.Match) so a single
+// registration can cover a family of tables.
+type TableMigratorRegistry struct {
+	mu      sync.RWMutex
+	entries []migratorEntry
+}
+
+type migratorEntry struct {
+	pattern  string
+	migrator TableMigrator
+}
+
+// NewTableMigratorRegistry returns an empty TableMigratorRegistry.
+func NewTableMigratorRegistry() *TableMigratorRegistry {
+	return &TableMigratorRegistry{}
+}
+
+// Register associates |m| with every table name matching |pattern|. Patterns
+// are matched with path.Match, so "?" and "*" behave as they do for shell
+// globs. Later registrations take precedence over earlier ones that match
+// the same table.
+func (r *TableMigratorRegistry) Register(pattern string, m TableMigrator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, migratorEntry{pattern: pattern, migrator: m})
+}
+
+// Lookup returns the most recently registered TableMigrator whose pattern
+// matches |table|, if any.
+func (r *TableMigratorRegistry) Lookup(table string) (TableMigrator, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		e := r.entries[i]
+		if ok, _ := path.Match(e.pattern, table); ok {
+			return e.migrator, true
+		}
+	}
+	return nil, false
+}
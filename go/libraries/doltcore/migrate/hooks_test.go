@@ -0,0 +1,87 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/store/types"
+	"github.com/dolthub/dolt/go/store/val"
+)
+
+// namedMigrator is a TableMigrator stub that identifies itself by name in
+// test assertions; it does no actual schema patching or row translation.
+type namedMigrator struct {
+	name string
+}
+
+func (m namedMigrator) PatchSchema(old schema.Schema) (schema.Schema, error) {
+	return old, nil
+}
+
+func (m namedMigrator) TranslateRow(ctx context.Context, oldKey, oldVal types.Tuple, newSch schema.Schema) (val.Tuple, val.Tuple, error) {
+	return nil, nil, nil
+}
+
+func TestTableMigratorRegistryLookup(t *testing.T) {
+	r := NewTableMigratorRegistry()
+
+	dolt := namedMigrator{name: "dolt"}
+	r.Register("dolt_*", dolt)
+
+	widgets := namedMigrator{name: "widgets"}
+	r.Register("widgets", widgets)
+
+	t.Run("matches exact name", func(t *testing.T) {
+		m, ok := r.Lookup("widgets")
+		if !ok || m.(namedMigrator).name != "widgets" {
+			t.Fatalf("expected widgets migrator, got %v, %v", m, ok)
+		}
+	})
+
+	t.Run("matches glob pattern", func(t *testing.T) {
+		m, ok := r.Lookup("dolt_schemas")
+		if !ok || m.(namedMigrator).name != "dolt" {
+			t.Fatalf("expected dolt migrator, got %v, %v", m, ok)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		_, ok := r.Lookup("other_table")
+		if ok {
+			t.Fatal("expected no migrator for unregistered table")
+		}
+	})
+
+	t.Run("later registration for the same table wins", func(t *testing.T) {
+		newer := namedMigrator{name: "widgets-v2"}
+		r.Register("widgets", newer)
+
+		m, ok := r.Lookup("widgets")
+		if !ok || m.(namedMigrator).name != "widgets-v2" {
+			t.Fatalf("expected most recently registered migrator, got %v, %v", m, ok)
+		}
+	})
+
+	t.Run("nil registry has no migrators", func(t *testing.T) {
+		var nilRegistry *TableMigratorRegistry
+		_, ok := nilRegistry.Lookup("widgets")
+		if ok {
+			t.Fatal("expected nil registry to report no migrator")
+		}
+	})
+}
@@ -0,0 +1,282 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/doltdb"
+	"github.com/dolthub/dolt/go/store/hash"
+)
+
+const (
+	// progressFileName is the boltdb file migration progress is recorded in,
+	// stored alongside the new DoltDB's chunk store under its .dolt dir.
+	progressFileName = "migration_progress.db"
+
+	commitsBucket  = "commits"  // old commit hash -> new commit hash
+	inflightBucket = "inflight" // old commit hash -> old parent commit hash, cleared on success
+	tablesBucket   = "tables"   // old commit hash + table name -> migrated table content hash
+	graftBucket    = "graft"    // old commit hash -> presence marker, cleared once extended with real history
+)
+
+// DurableProgress is a Progress implementation backed by a boltdb file on
+// disk. Unlike an in-memory Progress, it survives a process crash:
+// migrateCommit writes an in-flight marker for a commit before calling
+// CommitDangling, and it is cleared only once the batched flushRef SetHead
+// that covers it has succeeded (see graphScheduler.flush). On startup,
+// IsInFlight reports any commit whose marker was never cleared so
+// migrateGraph's scheduler can force it to be re-migrated instead of
+// trusting the commit mapping Put already wrote for it.
+type DurableProgress struct {
+	db *bbolt.DB
+}
+
+var _ Progress = (*DurableProgress)(nil)
+
+// OpenDurableProgress opens, or creates, the progress store rooted at |dir|,
+// which is expected to be the new DoltDB's .dolt directory.
+func OpenDurableProgress(dir string) (*DurableProgress, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(filepath.Join(dir, progressFileName), 0644, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range []string{commitsBucket, inflightBucket, tablesBucket, graftBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DurableProgress{db: db}, nil
+}
+
+// Close releases the underlying boltdb file.
+func (p *DurableProgress) Close() error {
+	return p.db.Close()
+}
+
+// Has implements Progress.
+func (p *DurableProgress) Has(ctx context.Context, old hash.Hash) (ok bool, err error) {
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		ok = tx.Bucket([]byte(commitsBucket)).Get(old[:]) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// Get implements Progress.
+func (p *DurableProgress) Get(ctx context.Context, old hash.Hash) (new hash.Hash, err error) {
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte(commitsBucket)).Get(old[:])
+		if v == nil {
+			return fmt.Errorf("migrate: no progress recorded for commit %s", old.String())
+		}
+		new = hash.New(v)
+		return nil
+	})
+	return new, err
+}
+
+// Put implements Progress. It only records the commit mapping: the
+// in-flight marker for |old| is cleared separately, by ClearInFlight, only
+// once the caller's post-commit flushRef SetHead has actually succeeded. If
+// Put also cleared it, a crash between Put and that SetHead would leave a
+// commit Has reports as done but whose ChunkStore flush never happened, and
+// Resume would never retry it.
+func (p *DurableProgress) Put(ctx context.Context, old, new hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(commitsBucket)).Put(old[:], new[:])
+	})
+}
+
+// Log implements Progress.
+func (p *DurableProgress) Log(ctx context.Context, msg string, args ...interface{}) {
+	fmt.Printf(msg+"\n", args...)
+}
+
+// MarkInFlight records that the commit |old| is about to be migrated on top
+// of the already-migrated |parent|. It must be called before CommitDangling
+// so that a crash mid-commit can be detected on resume.
+func (p *DurableProgress) MarkInFlight(ctx context.Context, old, parent hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(inflightBucket)).Put(old[:], parent[:])
+	})
+}
+
+// ClearInFlight clears the in-flight marker for |old|. It is called after
+// the post-commit flushRef SetHead succeeds.
+func (p *DurableProgress) ClearInFlight(ctx context.Context, old hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(inflightBucket)).Delete(old[:])
+	})
+}
+
+// InFlight returns the old commit hashes that were marked in-flight but
+// never cleared, meaning the previous run crashed while migrating them.
+func (p *DurableProgress) InFlight(ctx context.Context) (old []hash.Hash, err error) {
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(inflightBucket)).ForEach(func(k, v []byte) error {
+			old = append(old, hash.New(k))
+			return nil
+		})
+	})
+	return old, err
+}
+
+// IsInFlight reports whether |old| still carries an in-flight marker: its
+// commit mapping may already be in the commits bucket (Put runs after
+// CommitDangling but before the batched flushRef SetHead), but whether the
+// commit that mapping points at was ever made durable is unknown until
+// ClearInFlight runs. graphScheduler.computeRedo
+// uses this to force such commits to be re-migrated rather than trusting Has.
+func (p *DurableProgress) IsInFlight(ctx context.Context, old hash.Hash) (ok bool, err error) {
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		ok = tx.Bucket([]byte(inflightBucket)).Get(old[:]) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// RecordTable records the content hash of a table migrated as part of the
+// commit |old|. Status does not read these records; it only counts commits.
+func (p *DurableProgress) RecordTable(ctx context.Context, old hash.Hash, table string, contentHash hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(tablesBucket)).Put(tableKey(old, table), contentHash[:])
+	})
+}
+
+func tableKey(old hash.Hash, table string) []byte {
+	return append(append([]byte{}, old[:]...), []byte(table)...)
+}
+
+// RecordGraft marks |old|'s migrated commit as a synthetic graft root: it was
+// written with an empty parent list even though |old| itself has history
+// beyond it, because that history fell outside the configured ShallowOptions
+// bound. A later, deeper migration run consults IsGraft to know that |old|
+// must be re-migrated for real, against its actual parent, rather than
+// treated as already done.
+func (p *DurableProgress) RecordGraft(ctx context.Context, old hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(graftBucket)).Put(old[:], []byte{1})
+	})
+}
+
+// IsGraft reports whether |old|'s migrated commit was written as a synthetic
+// graft root by RecordGraft.
+func (p *DurableProgress) IsGraft(ctx context.Context, old hash.Hash) (ok bool, err error) {
+	err = p.db.View(func(tx *bbolt.Tx) error {
+		ok = tx.Bucket([]byte(graftBucket)).Get(old[:]) != nil
+		return nil
+	})
+	return ok, err
+}
+
+// ClearGraft removes |old|'s graft marker once a deeper run has re-migrated
+// it for real, attached to its actual parent.
+func (p *DurableProgress) ClearGraft(ctx context.Context, old hash.Hash) error {
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(graftBucket)).Delete(old[:])
+	})
+}
+
+// StatusReport summarizes, per branch, how much of a migration has
+// completed. It's the result returned by Status and printed by `migrate
+// status`.
+type StatusReport struct {
+	Branches []BranchStatus
+}
+
+// BranchStatus reports migration progress for a single branch.
+type BranchStatus struct {
+	Branch    string
+	Migrated  int
+	Remaining int
+}
+
+// Status walks the commit graph of |old| for every branch and, using the
+// records in |prog|, reports how many commits have already been migrated
+// and how many remain.
+func Status(ctx context.Context, old *doltdb.DoltDB, prog Progress) (StatusReport, error) {
+	branches, err := old.GetBranches(ctx)
+	if err != nil {
+		return StatusReport{}, err
+	}
+
+	var report StatusReport
+	for _, br := range branches {
+		cm, err := old.ResolveCommitRef(ctx, br)
+		if err != nil {
+			return StatusReport{}, err
+		}
+
+		hashes, err := doltdb.GetCommitAncestors(ctx, cm)
+		if err != nil {
+			return StatusReport{}, err
+		}
+
+		bs := BranchStatus{Branch: br.GetPath()}
+		for _, h := range hashes {
+			ok, err := prog.Has(ctx, h)
+			if err != nil {
+				return StatusReport{}, err
+			}
+			if ok {
+				bs.Migrated++
+			} else {
+				bs.Remaining++
+			}
+		}
+		report.Branches = append(report.Branches, bs)
+	}
+
+	return report, nil
+}
+
+// Resume opens the durable progress store under |new|'s .dolt directory. It
+// is the entry point for `migrate --resume`.
+//
+// It deliberately does not clear or retry anything itself: a commit can
+// still carry an in-flight marker because Put (which makes Has report it as
+// migrated) runs before the flushRef SetHead that would make the dangling
+// commit durable, so a crash in that window leaves Has and InFlight both
+// true for the same commit with no way here to tell whether the dangling
+// commit it points at actually exists in the new DoltDB. Deleting the
+// marker on open, as an earlier version of Resume did, would make Has the
+// only signal left and migrateGraph would never revisit the commit.
+// Instead the marker is left in place, and migrateGraph's scheduler (see
+// graphScheduler.computeRedo) consults IsInFlight to force such commits
+// through the same redo path used to extend a shallow clone's graft point,
+// clearing the marker itself once the re-migration actually flushes.
+func Resume(ctx context.Context, dir string) (*DurableProgress, error) {
+	return OpenDurableProgress(dir)
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dolthub/dolt/go/store/hash"
+)
+
+func TestDurableProgressCommitMappingRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	prog, err := OpenDurableProgress(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	old, new := hash.Hash{1}, hash.Hash{2}
+
+	if ok, err := prog.Has(ctx, old); err != nil || ok {
+		t.Fatalf("expected no mapping yet, got ok=%v err=%v", ok, err)
+	}
+
+	if err := prog.Put(ctx, old, new); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := prog.Has(ctx, old); err != nil || !ok {
+		t.Fatalf("expected mapping to be present, got ok=%v err=%v", ok, err)
+	}
+	got, err := prog.Get(ctx, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != new {
+		t.Fatalf("expected %s, got %s", new.String(), got.String())
+	}
+}
+
+// TestDurableProgressInFlightLifecycle exercises the marker migrateCommit
+// relies on for crash safety: set before CommitDangling, cleared only once
+// the scheduler's batched flush actually succeeds (see graphScheduler.flush).
+func TestDurableProgressInFlightLifecycle(t *testing.T) {
+	ctx := context.Background()
+	prog, err := OpenDurableProgress(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	old, parent := hash.Hash{1}, hash.Hash{2}
+
+	if ok, err := prog.IsInFlight(ctx, old); err != nil || ok {
+		t.Fatalf("expected no in-flight marker yet, got ok=%v err=%v", ok, err)
+	}
+
+	if err := prog.MarkInFlight(ctx, old, parent); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := prog.IsInFlight(ctx, old); err != nil || !ok {
+		t.Fatalf("expected in-flight marker to be set, got ok=%v err=%v", ok, err)
+	}
+
+	if err := prog.ClearInFlight(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := prog.IsInFlight(ctx, old); err != nil || ok {
+		t.Fatalf("expected in-flight marker to be cleared, got ok=%v err=%v", ok, err)
+	}
+}
+
+// TestResumeLeavesInFlightMarkersForScheduler reproduces the bug described in
+// Resume's doc comment: an earlier version of Resume cleared every in-flight
+// marker on open, which made Has the only signal migrateGraph had left for a
+// commit whose Put beat the crash that interrupted it, and migrateGraph would
+// never revisit it. Resume must leave the marker exactly as it found it so
+// graphScheduler.computeRedo (via needsForcedRedo) can force that commit
+// through the redo path instead.
+func TestResumeLeavesInFlightMarkersForScheduler(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	old, parent, migrated := hash.Hash{1}, hash.Hash{2}, hash.Hash{3}
+
+	prog, err := OpenDurableProgress(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the crash window migrateCommit documents: Put has already
+	// run (Has reports true), but the commit was never made durable and the
+	// in-flight marker was never cleared.
+	if err := prog.MarkInFlight(ctx, old, parent); err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.Put(ctx, old, migrated); err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := Resume(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+
+	if ok, err := resumed.Has(ctx, old); err != nil || !ok {
+		t.Fatalf("expected stale commit mapping to survive Resume, got ok=%v err=%v", ok, err)
+	}
+	if ok, err := resumed.IsInFlight(ctx, old); err != nil || !ok {
+		t.Fatalf("expected Resume to leave the in-flight marker in place, got ok=%v err=%v", ok, err)
+	}
+
+	forced, err := needsForcedRedo(ctx, resumed, old, false /* graft */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !forced {
+		t.Fatal("expected needsForcedRedo to force re-migration of an in-flight commit left by Resume")
+	}
+}
+
+func TestDurableProgressGraftLifecycle(t *testing.T) {
+	ctx := context.Background()
+	prog, err := OpenDurableProgress(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	old := hash.Hash{1}
+
+	if ok, err := prog.IsGraft(ctx, old); err != nil || ok {
+		t.Fatalf("expected no graft marker yet, got ok=%v err=%v", ok, err)
+	}
+
+	if err := prog.RecordGraft(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := prog.IsGraft(ctx, old); err != nil || !ok {
+		t.Fatalf("expected graft marker to be set, got ok=%v err=%v", ok, err)
+	}
+
+	if err := prog.ClearGraft(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := prog.IsGraft(ctx, old); err != nil || ok {
+		t.Fatalf("expected graft marker to be cleared, got ok=%v err=%v", ok, err)
+	}
+}
@@ -61,12 +61,12 @@ func migrateWorkingSet(ctx context.Context, brRef ref.BranchRef, wsRef ref.Worki
 		return err
 	}
 
-	wr, err := migrateRoot(ctx, oldHeadRoot, oldWs.WorkingRoot(), newHeadRoot)
+	wr, err := migrateRoot(ctx, oldHeadRoot, oldWs.WorkingRoot(), newHeadRoot, false /* dryRun */, nil, nil)
 	if err != nil {
 		return err
 	}
 
-	sr, err := migrateRoot(ctx, oldHeadRoot, oldWs.StagedRoot(), newHeadRoot)
+	sr, err := migrateRoot(ctx, oldHeadRoot, oldWs.StagedRoot(), newHeadRoot, false /* dryRun */, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -76,107 +76,195 @@ func migrateWorkingSet(ctx context.Context, brRef ref.BranchRef, wsRef ref.Worki
 	return new.UpdateWorkingSet(ctx, wsRef, newWs, hash.Hash{}, oldWs.Meta())
 }
 
-func migrateCommit(ctx context.Context, oldCm *doltdb.Commit, new *doltdb.DoltDB, prog Progress) error {
+// migrateCommit migrates |oldCm| onto |new|. |graft| marks |oldCm| as this
+// run's synthetic history boundary (see ShallowOptions); |redo| marks it as
+// needing to be re-migrated even though prog.Has already reports it done,
+// because it or an ancestor is being extended past a previous run's graft
+// point, or still carries an uncleared in-flight marker from a crashed run
+// (see graphScheduler.computeRedo).
+//
+// migrateCommit never flushes the ChunkStore itself: on success it reports
+// |needsFlush|, and the caller (graphScheduler) is responsible for the
+// batched flushRef SetHead and for clearing the in-flight/graft bookkeeping
+// once that flush actually succeeds (see graphScheduler.flush).
+func migrateCommit(ctx context.Context, oldCm *doltdb.Commit, new *doltdb.DoltDB, prog Progress, level ValidationLevel, report *ValidationReport, dryRun bool, stats *DryRunStats, registry *TableMigratorRegistry, graft, redo bool) (needsFlush bool, err error) {
 	oldHash, err := oldCm.HashOf()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	ok, err := prog.Has(ctx, oldHash)
-	if err != nil {
-		return err
-	} else if ok {
-		return nil
+	if !dryRun {
+		ok, err := prog.Has(ctx, oldHash)
+		if err != nil {
+			return false, err
+		} else if ok && !redo {
+			return false, nil
+		}
+		// if |redo|, |oldHash| was previously migrated as a synthetic graft
+		// root (or built on top of one) by a shallower run, or its migration
+		// was interrupted mid-flight by a crash; either way this run must
+		// fall through and re-migrate it for real, overwriting the now-stale
+		// or unverified mapping.
+	}
+
+	if graft {
+		if dryRun {
+			// translate the graft root's full content against an empty root, as migrateGraftCommit would
+			oldRoot, err := oldCm.GetRootValue(ctx)
+			if err != nil {
+				return false, err
+			}
+			emptyRoot, err := doltdb.EmptyRootValue(ctx, new.ValueReadWriter(), new.NodeStore())
+			if err != nil {
+				return false, err
+			}
+			if _, err = migrateRoot(ctx, emptyRoot, oldRoot, emptyRoot, true /* dryRun */, stats, registry); err != nil {
+				return false, err
+			}
+			if stats != nil && stats.visitCommit() {
+				stats.logProgress(ctx, prog)
+			}
+			return false, nil
+		}
+		if err := migrateGraftCommit(ctx, oldCm, new, prog, stats, registry); err != nil {
+			return false, err
+		}
+		return true, nil
 	}
 
 	if oldCm.NumParents() == 0 {
-		return migrateInitCommit(ctx, oldCm, new, prog)
+		if dryRun {
+			// nothing to migrate, and nothing was written to record progress against
+			if stats != nil && stats.visitCommit() {
+				stats.logProgress(ctx, prog)
+			}
+			return false, nil
+		}
+		if err := migrateInitCommit(ctx, oldCm, new, prog); err != nil {
+			return false, err
+		}
+		if dp, ok := prog.(*DurableProgress); ok {
+			return false, dp.ClearGraft(ctx, oldHash)
+		}
+		return false, nil
 	}
 
 	prog.Log(ctx, "migrating commit %s", oldHash.String())
 
 	oldRoot, err := oldCm.GetRootValue(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	oldParentCm, err := oldCm.GetParent(ctx, 0)
 	if err != nil {
-		return err
+		return false, err
 	}
 	oldParentRoot, err := oldParentCm.GetRootValue(ctx)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	oph, err := oldParentCm.HashOf()
 	if err != nil {
-		return err
-	}
-	ok, err = prog.Has(ctx, oph)
-	if err != nil {
-		return err
-	} else if !ok {
-		return fmt.Errorf("cannot find commit mapping for Commit (%s)", oph.String())
+		return false, err
 	}
 
-	newParentAddr, err := prog.Get(ctx, oph)
-	if err != nil {
-		return err
+	var newParentRoot *doltdb.RootValue
+	if dryRun {
+		// no commit has actually been written for |oph|; diff against an
+		// empty root purely to exercise the same translation path and
+		// gather statistics
+		newParentRoot, err = doltdb.EmptyRootValue(ctx, new.ValueReadWriter(), new.NodeStore())
+		if err != nil {
+			return false, err
+		}
+	} else {
+		ok, err := prog.Has(ctx, oph)
+		if err != nil {
+			return false, err
+		} else if !ok {
+			return false, fmt.Errorf("cannot find commit mapping for Commit (%s)", oph.String())
+		}
+
+		newParentAddr, err := prog.Get(ctx, oph)
+		if err != nil {
+			return false, err
+		}
+		newParentCm, err := new.ReadCommit(ctx, newParentAddr)
+		if err != nil {
+			return false, err
+		}
+		newParentRoot, err = newParentCm.GetRootValue(ctx)
+		if err != nil {
+			return false, err
+		}
 	}
-	newParentCm, err := new.ReadCommit(ctx, newParentAddr)
+
+	mRoot, err := migrateRoot(ctx, oldParentRoot, oldRoot, newParentRoot, dryRun, stats, registry)
 	if err != nil {
-		return err
+		return false, err
 	}
-	newParentRoot, err := newParentCm.GetRootValue(ctx)
-	if err != nil {
-		return err
+
+	if dryRun {
+		if stats != nil && stats.visitCommit() {
+			stats.logProgress(ctx, prog)
+		}
+		return false, nil
 	}
 
-	mRoot, err := migrateRoot(ctx, oldParentRoot, oldRoot, newParentRoot)
-	if err != nil {
-		return err
+	if err = recordMigratedTables(ctx, prog, oldHash, mRoot); err != nil {
+		return false, err
 	}
+
 	_, addr, err := new.WriteRootValue(ctx, mRoot)
 	if err != nil {
-		return err
+		return false, err
 	}
 	value, err := new.ValueReadWriter().ReadValue(ctx, addr)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	opts, err := migrateCommitOptions(ctx, oldCm, prog)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	// if |prog| is durable, record that |oldHash| is about to be migrated on
+	// top of |oph| so a crash before the commit is made durable below, or
+	// before the caller's batched flush covers it, can be detected and
+	// retried on resume
+	if dp, ok := prog.(*DurableProgress); ok {
+		if err = dp.MarkInFlight(ctx, oldHash, oph); err != nil {
+			return false, err
+		}
 	}
 
 	migratedCm, err := new.CommitDangling(ctx, value, opts)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	// update progress
 	newHash, err := migratedCm.HashOf()
 	if err != nil {
-		return err
+		return false, err
 	}
 	if err = prog.Put(ctx, oldHash, newHash); err != nil {
-		return err
-	}
-
-	// flush ChunkStore
-	if err = new.SetHead(ctx, flushRef, newHash); err != nil {
-		return err
+		return false, err
 	}
 
-	// validate root after we flush the ChunkStore to facilitate
-	// investigating failed migrations
-	if err = validateRootValue(ctx, oldRoot, mRoot); err != nil {
-		return err
+	// validate before the caller's batched ChunkStore flush: the migrated
+	// value is already readable through |new|'s ValueReadWriter regardless
+	// of flushRef, and running validation early surfaces a bad translation
+	// before it's mixed into a multi-commit flush batch
+	validators := validatorsForLevel(level)
+	if err = runValidators(ctx, validators, oldHash.String(), oldRoot, mRoot, report); err != nil {
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
 func migrateInitCommit(ctx context.Context, cm *doltdb.Commit, new *doltdb.DoltDB, prog Progress) error {
@@ -219,6 +307,128 @@ func migrateInitCommit(ctx context.Context, cm *doltdb.Commit, new *doltdb.DoltD
 	return prog.Put(ctx, oldHash, newHash)
 }
 
+// needsForcedRedo reports whether a migration should re-migrate |oldHash|
+// for real, even though prog.Has already reports it as migrated. Two cases
+// force this:
+//   - this run no longer treats |oldHash| as a graft point (|graft| is
+//     false) but an earlier, shallower run recorded it as one, so its
+//     existing mapping only covers a synthetic, parentless commit
+//   - |oldHash| still carries an uncleared in-flight marker: Put (which
+//     makes Has report it as done) runs before the commit is made durable
+//     and before the flushRef SetHead that would clear the marker, so a
+//     crash in that window leaves no way to tell whether the mapping Has
+//     found actually points at anything real
+//
+// A Progress that isn't a *DurableProgress has no graft or in-flight
+// bookkeeping and can never trigger either case.
+func needsForcedRedo(ctx context.Context, prog Progress, oldHash hash.Hash, graft bool) (bool, error) {
+	dp, ok := prog.(*DurableProgress)
+	if !ok {
+		return false, nil
+	}
+	if !graft {
+		isGraft, err := dp.IsGraft(ctx, oldHash)
+		if err != nil || isGraft {
+			return isGraft, err
+		}
+	}
+	return dp.IsInFlight(ctx, oldHash)
+}
+
+// recordMigratedTables persists the content hash of every table in
+// |root|, keyed by |oldHash|. Status does not read these records; it only
+// counts commits. It is a no-op unless |prog| is a *DurableProgress.
+func recordMigratedTables(ctx context.Context, prog Progress, oldHash hash.Hash, root *doltdb.RootValue) error {
+	dp, ok := prog.(*DurableProgress)
+	if !ok {
+		return nil
+	}
+
+	return root.IterTables(ctx, func(name string, tbl *doltdb.Table, sch schema.Schema) (bool, error) {
+		contentHash, err := tbl.HashOf()
+		if err != nil {
+			return true, err
+		}
+		if err = dp.RecordTable(ctx, oldHash, name, contentHash); err != nil {
+			return true, err
+		}
+		return false, nil
+	})
+}
+
+// migrateGraftCommit migrates |cm| as a synthetic graft root: a commit whose
+// parent list is empty even though |cm| itself has older history, because
+// that history fell outside the migration's ShallowOptions bound. Unlike
+// migrateInitCommit's single, canonical, empty-root creation commit, a graft
+// root carries the full materialized content of |cm| translated with no
+// parent to diff against, so every table is treated as newly added.
+//
+// Like migrateCommit, it does not flush the ChunkStore or clear the
+// in-flight marker it sets; the caller batches that (see
+// graphScheduler.flush), which also decides between RecordGraft and
+// ClearGraft since only it knows the commit's final redo/graft status.
+func migrateGraftCommit(ctx context.Context, cm *doltdb.Commit, new *doltdb.DoltDB, prog Progress, stats *DryRunStats, registry *TableMigratorRegistry) error {
+	oldHash, err := cm.HashOf()
+	if err != nil {
+		return err
+	}
+
+	oldRoot, err := cm.GetRootValue(ctx)
+	if err != nil {
+		return err
+	}
+
+	emptyRoot, err := doltdb.EmptyRootValue(ctx, new.ValueReadWriter(), new.NodeStore())
+	if err != nil {
+		return err
+	}
+
+	mRoot, err := migrateRoot(ctx, emptyRoot, oldRoot, emptyRoot, false /* dryRun */, stats, registry)
+	if err != nil {
+		return err
+	}
+
+	if err = recordMigratedTables(ctx, prog, oldHash, mRoot); err != nil {
+		return err
+	}
+
+	_, addr, err := new.WriteRootValue(ctx, mRoot)
+	if err != nil {
+		return err
+	}
+	value, err := new.ValueReadWriter().ReadValue(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	meta, err := cm.GetCommitMeta(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a graft root has no parent to mark in-flight against, but it still
+	// needs the same crash-safety guard as the normal commit path: without
+	// it, a crash between Put below and the caller's batched flush would
+	// leave a mapping that Has reports as done for a commit that was never
+	// flushed, and resume would silently skip it instead of retrying.
+	if dp, ok := prog.(*DurableProgress); ok {
+		if err = dp.MarkInFlight(ctx, oldHash, hash.Hash{}); err != nil {
+			return err
+		}
+	}
+
+	migratedCm, err := new.CommitDangling(ctx, value, datas.CommitOptions{Meta: meta})
+	if err != nil {
+		return err
+	}
+
+	newHash, err := migratedCm.HashOf()
+	if err != nil {
+		return err
+	}
+	return prog.Put(ctx, oldHash, newHash)
+}
+
 func migrateCommitOptions(ctx context.Context, oldCm *doltdb.Commit, prog Progress) (datas.CommitOptions, error) {
 	parents, err := oldCm.ParentHashes(ctx)
 	if err != nil {
@@ -247,7 +457,7 @@ func migrateCommitOptions(ctx context.Context, oldCm *doltdb.Commit, prog Progre
 	}, nil
 }
 
-func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.RootValue) (*doltdb.RootValue, error) {
+func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.RootValue, dryRun bool, stats *DryRunStats, registry *TableMigratorRegistry) (*doltdb.RootValue, error) {
 	migrated := newParent
 
 	fkc, err := oldRoot.GetForeignKeyCollection(ctx)
@@ -255,9 +465,11 @@ func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.Root
 		return nil, err
 	}
 
-	migrated, err = migrated.PutForeignKeyCollection(ctx, fkc)
-	if err != nil {
-		return nil, err
+	if !dryRun {
+		migrated, err = migrated.PutForeignKeyCollection(ctx, fkc)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	err = oldRoot.IterTables(ctx, func(name string, oldTbl *doltdb.Table, sch schema.Schema) (bool, error) {
@@ -268,17 +480,31 @@ func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.Root
 			return true, fmt.Errorf("cannot migrate table with conflicts (%s)", name)
 		}
 
-		// maybe patch dolt_schemas, dolt docs
+		// consult the TableMigrator registry first; fall back to patching
+		// only dolt_schemas/dolt_docs otherwise
 		var newSch schema.Schema
-		if doltdb.HasDoltPrefix(name) {
+		var patchedSchema bool
+		migrator, hasMigrator := registry.Lookup(name)
+		switch {
+		case hasMigrator:
+			if newSch, err = migrator.PatchSchema(sch); err != nil {
+				return true, err
+			}
+			patchedSchema = !schema.SchemasAreEqual(newSch, sch)
+		case doltdb.HasDoltPrefix(name):
 			if newSch, err = patchMigrateSchema(ctx, sch); err != nil {
 				return true, err
 			}
-		} else {
+			patchedSchema = !schema.SchemasAreEqual(newSch, sch)
+		default:
 			newSch = sch
 		}
-		if err = validateSchema(newSch); err != nil {
-			return true, err
+		schemaErr := validateSchema(newSch)
+		if !dryRun && schemaErr != nil {
+			return true, schemaErr
+		}
+		if stats != nil {
+			stats.addTable(patchedSchema, schemaErr != nil)
 		}
 
 		// if there was a schema change in this commit,
@@ -315,14 +541,16 @@ func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.Root
 			}
 		}
 
-		mtbl, err := migrateTable(ctx, newSch, oldParentTbl, oldTbl, newParentTbl)
+		mtbl, err := migrateTable(ctx, newSch, oldParentTbl, oldTbl, newParentTbl, dryRun, stats, migrator)
 		if err != nil {
 			return true, err
 		}
 
-		migrated, err = migrated.PutTable(ctx, name, mtbl)
-		if err != nil {
-			return true, err
+		if !dryRun {
+			migrated, err = migrated.PutTable(ctx, name, mtbl)
+			if err != nil {
+				return true, err
+			}
 		}
 		return false, nil
 	})
@@ -333,7 +561,7 @@ func migrateRoot(ctx context.Context, oldParent, oldRoot, newParent *doltdb.Root
 	return migrated, nil
 }
 
-func migrateTable(ctx context.Context, newSch schema.Schema, oldParentTbl, oldTbl, newParentTbl *doltdb.Table) (*doltdb.Table, error) {
+func migrateTable(ctx context.Context, newSch schema.Schema, oldParentTbl, oldTbl, newParentTbl *doltdb.Table, dryRun bool, stats *DryRunStats, migrator TableMigrator) (*doltdb.Table, error) {
 	idx, err := oldParentTbl.GetRowData(ctx)
 	if err != nil {
 		return nil, err
@@ -372,13 +600,16 @@ func migrateTable(ctx context.Context, newSch schema.Schema, oldParentTbl, oldTb
 	eg, ctx := errgroup.WithContext(ctx)
 
 	eg.Go(func() error {
-		newRows, err = migrateIndex(ctx, newSch, oldParentRows, oldRows, newParentRows, newParentTbl.NodeStore())
+		// only the primary row data is handed to a registered TableMigrator;
+		// secondary indexes are translated from the old index data by the
+		// default translator in migrateIndexSet below, not from its output
+		newRows, err = migrateIndex(ctx, newSch, oldParentRows, oldRows, newParentRows, newParentTbl.NodeStore(), dryRun, stats, migrator)
 		return err
 	})
 
 	vrw, ns := newParentTbl.ValueReadWriter(), newParentTbl.NodeStore()
 	eg.Go(func() error {
-		newSet, err = migrateIndexSet(ctx, newSch, oldParentSet, oldSet, newParentSet, vrw, ns)
+		newSet, err = migrateIndexSet(ctx, newSch, oldParentSet, oldSet, newParentSet, vrw, ns, dryRun, stats)
 		return err
 	})
 
@@ -386,6 +617,10 @@ func migrateTable(ctx context.Context, newSch schema.Schema, oldParentTbl, oldTb
 		return nil, err
 	}
 
+	if dryRun {
+		return nil, nil
+	}
+
 	ai, err := oldTbl.GetAutoIncrementValue(ctx)
 	if err != nil {
 		return nil, err
@@ -420,6 +655,7 @@ func migrateIndexSet(
 	sch schema.Schema,
 	oldParentSet, oldSet, newParentSet durable.IndexSet,
 	vrw types.ValueReadWriter, ns tree.NodeStore,
+	dryRun bool, stats *DryRunStats,
 ) (durable.IndexSet, error) {
 	newSet := durable.NewIndexSet(ctx, vrw, ns)
 	for _, def := range sch.Indexes().AllIndexes() {
@@ -441,11 +677,15 @@ func migrateIndexSet(
 		}
 		newParent := durable.ProllyMapFromIndex(idx)
 
-		newIdx, err := migrateIndex(ctx, def.Schema(), oldParent, old, newParent, ns)
+		newIdx, err := migrateIndex(ctx, def.Schema(), oldParent, old, newParent, ns, dryRun, stats, nil /* migrator */)
 		if err != nil {
 			return nil, err
 		}
 
+		if dryRun {
+			continue
+		}
+
 		newSet, err = newSet.PutIndex(ctx, def.Name(), newIdx)
 		if err != nil {
 			return nil, err
@@ -460,6 +700,8 @@ func migrateIndex(
 	oldParent, oldMap types.Map,
 	newParent prolly.Map,
 	ns tree.NodeStore,
+	dryRun bool, stats *DryRunStats,
+	migrator TableMigrator,
 ) (durable.Index, error) {
 
 	eg, ctx := errgroup.WithContext(ctx)
@@ -474,12 +716,27 @@ func migrateIndex(
 		return oldMap.Diff(ctx, oldParent, differ)
 	})
 
-	// translate noms tuples to prolly tuples
+	// translate noms tuples to prolly tuples, preferring a registered
+	// TableMigrator's TranslateRow over the default translator pair
 	eg.Go(func() error {
 		defer close(writer)
-		return translateTuples(ctx, kt, vt, differ, writer)
+		return translateTuples(ctx, kt, vt, sch, migrator, differ, writer, stats)
 	})
 
+	if dryRun {
+		// drain the writer channel instead of building a new prolly map;
+		// translateTuples still ran and accounted for every row
+		eg.Go(func() error {
+			for range writer {
+			}
+			return nil
+		})
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
 	var newMap prolly.Map
 	// write tuples in new prolly map
 	eg.Go(func() (err error) {
@@ -494,7 +751,7 @@ func migrateIndex(
 	return durable.IndexFromProllyMap(newMap), nil
 }
 
-func translateTuples(ctx context.Context, kt, vt translator, differ <-chan types.ValueChanged, writer chan<- val.Tuple) error {
+func translateTuples(ctx context.Context, kt, vt translator, sch schema.Schema, migrator TableMigrator, differ <-chan types.ValueChanged, writer chan<- val.Tuple, stats *DryRunStats) error {
 	for {
 		var (
 			diff   types.ValueChanged
@@ -514,23 +771,45 @@ func translateTuples(ctx context.Context, kt, vt translator, differ <-chan types
 		}
 
 		switch diff.ChangeType {
-		case types.DiffChangeAdded:
-			fallthrough
-
-		case types.DiffChangeModified:
+		case types.DiffChangeAdded, types.DiffChangeModified:
+			if migrator != nil {
+				newKey, newVal, err = migrator.TranslateRow(ctx, diff.Key.(types.Tuple), diff.NewValue.(types.Tuple), sch)
+				if err != nil {
+					return err
+				}
+				break
+			}
 			newVal, err = vt.TranslateTuple(ctx, diff.NewValue.(types.Tuple))
 			if err != nil {
 				return err
 			}
-			fallthrough
+			newKey, err = kt.TranslateTuple(ctx, diff.Key.(types.Tuple))
+			if err != nil {
+				return err
+			}
 
 		case types.DiffChangeRemoved:
+			if migrator != nil {
+				// use the same TranslateRow path the row's add/modify used,
+				// so a migrator that changes key encoding (e.g. an enum
+				// rename that is part of the PK) produces a matching
+				// tombstone key rather than a default-encoded one
+				newKey, _, err = migrator.TranslateRow(ctx, diff.Key.(types.Tuple), diff.OldValue.(types.Tuple), sch)
+				if err != nil {
+					return err
+				}
+				break
+			}
 			newKey, err = kt.TranslateTuple(ctx, diff.Key.(types.Tuple))
 			if err != nil {
 				return err
 			}
 		}
 
+		if stats != nil {
+			stats.addRow(len(newKey) + len(newVal))
+		}
+
 		select {
 		case writer <- newKey:
 		case _ = <-ctx.Done():
@@ -577,4 +856,4 @@ func (p channelProvider) Next(ctx context.Context) (val.Tuple, val.Tuple) {
 		return nil, nil
 	}
 	return k, v
-}
\ No newline at end of file
+}
@@ -0,0 +1,443 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/dolthub/dolt/go/libraries/doltcore/doltdb"
+	"github.com/dolthub/dolt/go/libraries/doltcore/doltdb/durable"
+	"github.com/dolthub/dolt/go/libraries/doltcore/schema"
+	"github.com/dolthub/dolt/go/store/prolly"
+	"github.com/dolthub/dolt/go/store/prolly/tree"
+	"github.com/dolthub/dolt/go/store/val"
+)
+
+// ValidationLevel selects which Validators run after each commit is
+// migrated. Levels are ordered from cheapest/weakest to most
+// expensive/strongest; ValidateAll runs every Validator.
+type ValidationLevel int
+
+const (
+	ValidateNone ValidationLevel = iota
+	ValidateSchema
+	ValidateCounts
+	ValidateRows
+	ValidateIndexes
+	ValidateAll
+)
+
+// ParseValidationLevel parses the `--validate` flag value.
+func ParseValidationLevel(s string) (ValidationLevel, error) {
+	switch s {
+	case "none":
+		return ValidateNone, nil
+	case "schema":
+		return ValidateSchema, nil
+	case "counts":
+		return ValidateCounts, nil
+	case "rows":
+		return ValidateRows, nil
+	case "indexes":
+		return ValidateIndexes, nil
+	case "all":
+		return ValidateAll, nil
+	default:
+		return ValidateNone, fmt.Errorf("unknown validation level: %s", s)
+	}
+}
+
+// validatorsForLevel returns the Validators that |level| runs, in the order
+// they should be applied.
+func validatorsForLevel(level ValidationLevel) []Validator {
+	switch level {
+	case ValidateNone:
+		return nil
+	case ValidateSchema:
+		return []Validator{SchemaOnlyValidator{}}
+	case ValidateCounts:
+		return []Validator{SchemaOnlyValidator{}, RowCountValidator{}}
+	case ValidateRows:
+		return []Validator{SchemaOnlyValidator{}, FullRowEqualityValidator{}}
+	case ValidateIndexes:
+		return []Validator{SchemaOnlyValidator{}, FullRowEqualityValidator{}, IndexConsistencyValidator{}}
+	case ValidateAll:
+		return []Validator{SchemaOnlyValidator{}, RowCountValidator{}, FullRowEqualityValidator{}, IndexConsistencyValidator{}}
+	default:
+		return nil
+	}
+}
+
+// Validator checks a single migrated table against its pre-migration
+// counterpart and reports whether the migration preserved whatever
+// invariant the Validator is responsible for.
+type Validator interface {
+	// Name identifies the Validator in a ValidationResult.
+	Name() string
+	// Validate compares the table |name| as it existed in |oldRoot| against
+	// its migrated counterpart in |newRoot|.
+	Validate(ctx context.Context, name string, oldRoot, newRoot *doltdb.RootValue) (ValidationResult, error)
+}
+
+// ValidationResult is the outcome of running a single Validator against a
+// single table within a single migrated commit.
+type ValidationResult struct {
+	Commit    string
+	Table     string
+	Validator string
+	Passed    bool
+	Detail    string
+}
+
+// ValidationReport accumulates ValidationResults across every commit
+// migrated in a run, so a post-migration summary can be printed per table
+// per commit. It is safe for concurrent use by migrateGraph's worker pool.
+type ValidationReport struct {
+	mu      sync.Mutex
+	Results []ValidationResult
+}
+
+// NewValidationReport returns an empty ValidationReport.
+func NewValidationReport() *ValidationReport {
+	return &ValidationReport{}
+}
+
+func (r *ValidationReport) record(results ...ValidationResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, results...)
+}
+
+// Failures returns every recorded ValidationResult that did not pass.
+func (r *ValidationReport) Failures() []ValidationResult {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var failed []ValidationResult
+	for _, res := range r.Results {
+		if !res.Passed {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// runValidators runs every Validator in |validators| over every table
+// present in |oldRoot|, recording each ValidationResult in |report| (which
+// may be nil), and returns an error for the first failing result.
+func runValidators(ctx context.Context, validators []Validator, commit string, oldRoot, newRoot *doltdb.RootValue, report *ValidationReport) error {
+	if len(validators) == 0 {
+		return nil
+	}
+
+	return oldRoot.IterTables(ctx, func(name string, _ *doltdb.Table, _ schema.Schema) (bool, error) {
+		for _, v := range validators {
+			res, err := v.Validate(ctx, name, oldRoot, newRoot)
+			if err != nil {
+				return true, err
+			}
+			res.Commit = commit
+
+			report.record(res)
+			if !res.Passed {
+				return true, fmt.Errorf("validator %q failed for table %q: %s", res.Validator, res.Table, res.Detail)
+			}
+		}
+		return false, nil
+	})
+}
+
+// SchemaOnlyValidator checks that the migrated table exists and has the same
+// number of columns as its pre-migration counterpart, without touching row
+// data; column names and types are not compared. It's the cheapest
+// Validator and the one every other level also runs.
+type SchemaOnlyValidator struct{}
+
+func (SchemaOnlyValidator) Name() string { return "schema" }
+
+func (SchemaOnlyValidator) Validate(ctx context.Context, name string, oldRoot, newRoot *doltdb.RootValue) (ValidationResult, error) {
+	res := ValidationResult{Table: name, Validator: "schema"}
+
+	oldTbl, ok, err := oldRoot.GetTable(ctx, name)
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		res.Passed = true // table didn't exist pre-migration; nothing to check
+		return res, nil
+	}
+	oldSch, err := oldTbl.GetSchema(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	newTbl, ok, err := newRoot.GetTable(ctx, name)
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		res.Detail = "table missing from migrated root"
+		return res, nil
+	}
+	newSch, err := newTbl.GetSchema(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	if newSch.GetAllCols().Size() != oldSch.GetAllCols().Size() {
+		res.Detail = fmt.Sprintf("column count mismatch: old %d, new %d", oldSch.GetAllCols().Size(), newSch.GetAllCols().Size())
+		return res, nil
+	}
+
+	res.Passed = true
+	return res, nil
+}
+
+// RowCountValidator checks that the migrated table has the same number of
+// rows as its pre-migration counterpart, without comparing row contents.
+type RowCountValidator struct{}
+
+func (RowCountValidator) Name() string { return "counts" }
+
+func (RowCountValidator) Validate(ctx context.Context, name string, oldRoot, newRoot *doltdb.RootValue) (ValidationResult, error) {
+	res := ValidationResult{Table: name, Validator: "counts"}
+
+	oldTbl, ok, err := oldRoot.GetTable(ctx, name)
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		res.Passed = true
+		return res, nil
+	}
+	oldRows, err := oldTbl.GetRowData(ctx)
+	if err != nil {
+		return res, err
+	}
+	oldCount, err := oldRows.Count()
+	if err != nil {
+		return res, err
+	}
+
+	newTbl, ok, err := newRoot.GetTable(ctx, name)
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		res.Detail = "table missing from migrated root"
+		return res, nil
+	}
+	newRows, err := newTbl.GetRowData(ctx)
+	if err != nil {
+		return res, err
+	}
+	newCount, err := newRows.Count()
+	if err != nil {
+		return res, err
+	}
+
+	if oldCount != newCount {
+		res.Detail = fmt.Sprintf("row count mismatch: old %d, new %d", oldCount, newCount)
+		return res, nil
+	}
+
+	res.Passed = true
+	return res, nil
+}
+
+// FullRowEqualityValidator compares every row's contents between the
+// pre-migration and migrated tables. This was previously the only
+// validation migrateCommit performed, via the old validateRootValue helper.
+type FullRowEqualityValidator struct{}
+
+func (FullRowEqualityValidator) Name() string { return "rows" }
+
+func (FullRowEqualityValidator) Validate(ctx context.Context, name string, oldRoot, newRoot *doltdb.RootValue) (ValidationResult, error) {
+	res := ValidationResult{Table: name, Validator: "rows"}
+
+	// validateRootValue compares every table present in the roots it's
+	// given; scope it down to |name| so runValidators' per-table loop
+	// checks each table once instead of re-checking the whole commit N
+	// times and attributing whichever mismatch it hits first to whichever
+	// table happens to be current in that loop iteration.
+	scopedOld, err := scopeRootToTable(ctx, oldRoot, name)
+	if err != nil {
+		return res, err
+	}
+	scopedNew, err := scopeRootToTable(ctx, newRoot, name)
+	if err != nil {
+		return res, err
+	}
+
+	if err := validateRootValue(ctx, scopedOld, scopedNew); err != nil {
+		res.Detail = err.Error()
+		return res, nil
+	}
+	res.Passed = true
+	return res, nil
+}
+
+// scopeRootToTable returns a root containing only the table |name|, if
+// present in |root|, so FullRowEqualityValidator can hand validateRootValue
+// just the one table it was asked to check.
+func scopeRootToTable(ctx context.Context, root *doltdb.RootValue, name string) (*doltdb.RootValue, error) {
+	empty, err := doltdb.EmptyRootValue(ctx, root.VRW(), root.NodeStore())
+	if err != nil {
+		return nil, err
+	}
+
+	tbl, ok, err := root.GetTable(ctx, name)
+	if err != nil || !ok {
+		return empty, err
+	}
+
+	return empty.PutTable(ctx, name, tbl)
+}
+
+// IndexConsistencyValidator re-derives each secondary index from the
+// migrated primary row data using the prolly builders migrateIndexSet
+// already depends on, then compares the result against the index set
+// migrateIndexSet actually wrote. This catches translateTuples bugs that
+// only manifest in a secondary index and that row-equality validation on
+// the primary index misses.
+type IndexConsistencyValidator struct{}
+
+func (IndexConsistencyValidator) Name() string { return "indexes" }
+
+func (IndexConsistencyValidator) Validate(ctx context.Context, name string, oldRoot, newRoot *doltdb.RootValue) (ValidationResult, error) {
+	res := ValidationResult{Table: name, Validator: "indexes"}
+
+	newTbl, ok, err := newRoot.GetTable(ctx, name)
+	if err != nil {
+		return res, err
+	}
+	if !ok {
+		res.Passed = true
+		return res, nil
+	}
+
+	sch, err := newTbl.GetSchema(ctx)
+	if err != nil {
+		return res, err
+	}
+	writtenSet, err := newTbl.GetIndexSet(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	rederivedSet, err := rebuildIndexSetFromPrimary(ctx, sch, newTbl)
+	if err != nil {
+		return res, err
+	}
+
+	writtenHash, err := writtenSet.HashOf()
+	if err != nil {
+		return res, err
+	}
+	rederivedHash, err := rederivedSet.HashOf()
+	if err != nil {
+		return res, err
+	}
+
+	if writtenHash != rederivedHash {
+		res.Detail = fmt.Sprintf("secondary index hash mismatch: written %s, rederived %s", writtenHash.String(), rederivedHash.String())
+		return res, nil
+	}
+
+	res.Passed = true
+	return res, nil
+}
+
+// rebuildIndexSetFromPrimary re-derives every secondary index of |tbl| from
+// its migrated primary row data, the same way the row-data path in
+// migrateTable populates a new prolly map, so the result can be compared
+// against the durable.IndexSet migrateIndexSet actually wrote.
+func rebuildIndexSetFromPrimary(ctx context.Context, sch schema.Schema, tbl *doltdb.Table) (durable.IndexSet, error) {
+	idx, err := tbl.GetRowData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows := durable.ProllyMapFromIndex(idx)
+
+	newSet := durable.NewIndexSet(ctx, tbl.ValueReadWriter(), tbl.NodeStore())
+	for _, def := range sch.Indexes().AllIndexes() {
+		rebuilt, err := rebuildSecondaryIndex(ctx, def, rows, tbl.NodeStore())
+		if err != nil {
+			return nil, err
+		}
+		newSet, err = newSet.PutIndex(ctx, def.Name(), rebuilt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newSet, nil
+}
+
+// rebuildSecondaryIndex projects every row of |rows| through |def|'s key and
+// value mapping and writes the result into a fresh prolly map, the same
+// builder migrateIndex already uses to write a durable.Index.
+func rebuildSecondaryIndex(ctx context.Context, def schema.Index, rows prolly.Map, ns tree.NodeStore) (durable.Index, error) {
+	empty, err := prolly.NewMapFromTuples(ctx, ns, rows.KeyDesc(), rows.ValDesc())
+	if err != nil {
+		return nil, err
+	}
+
+	tuples := make(chan val.Tuple, 256)
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		defer close(tuples)
+		return rows.IterAll(ctx, func(k, v val.Tuple) error {
+			ik, iv, err := def.ProjectTuples(k, v)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case tuples <- ik:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			select {
+			case tuples <- iv:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+	})
+
+	var newMap prolly.Map
+	eg.Go(func() (err error) {
+		newMap, err = writeProllyMap(ctx, empty, tuples)
+		return
+	})
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	return durable.IndexFromProllyMap(newMap), nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Dolthub, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package migrate
+
+import "testing"
+
+// TestValidatorsForLevel pins down which Validators each ValidationLevel
+// runs, and that every level beyond schema-only still runs SchemaOnlyValidator
+// first, since it's the cheapest check and every other level builds on it.
+func TestValidatorsForLevel(t *testing.T) {
+	tests := []struct {
+		level ValidationLevel
+		names []string
+	}{
+		{ValidateNone, nil},
+		{ValidateSchema, []string{"schema"}},
+		{ValidateCounts, []string{"schema", "counts"}},
+		{ValidateRows, []string{"schema", "rows"}},
+		{ValidateIndexes, []string{"schema", "rows", "indexes"}},
+		{ValidateAll, []string{"schema", "counts", "rows", "indexes"}},
+	}
+
+	for _, tt := range tests {
+		validators := validatorsForLevel(tt.level)
+		if len(validators) != len(tt.names) {
+			t.Fatalf("level %d: expected %v, got %d validators", tt.level, tt.names, len(validators))
+		}
+		for i, v := range validators {
+			if v.Name() != tt.names[i] {
+				t.Errorf("level %d: expected validator %d to be %q, got %q", tt.level, i, tt.names[i], v.Name())
+			}
+		}
+	}
+}
+
+func TestParseValidationLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ValidationLevel
+		wantErr bool
+	}{
+		{"none", ValidateNone, false},
+		{"schema", ValidateSchema, false},
+		{"counts", ValidateCounts, false},
+		{"rows", ValidateRows, false},
+		{"indexes", ValidateIndexes, false},
+		{"all", ValidateAll, false},
+		{"bogus", ValidateNone, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseValidationLevel(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseValidationLevel(%q): unexpected error state, err=%v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseValidationLevel(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestGraphOptionsWithDefaultsHonorsExplicitNone reproduces the bug where
+// withDefaults treated ValidateNone and "Level never set" as the same thing,
+// since ValidateNone is Level's zero value: an explicit --validate=none
+// (LevelSet: true) was silently promoted back to ValidateRows, same as a
+// GraphOptions whose caller never touched Level at all.
+func TestGraphOptionsWithDefaultsHonorsExplicitNone(t *testing.T) {
+	unset := GraphOptions{}.withDefaults()
+	if unset.Level != ValidateRows {
+		t.Errorf("expected an untouched Level to default to ValidateRows, got %d", unset.Level)
+	}
+
+	explicitNone := GraphOptions{Level: ValidateNone, LevelSet: true}.withDefaults()
+	if explicitNone.Level != ValidateNone {
+		t.Errorf("expected an explicit ValidateNone to survive withDefaults, got %d", explicitNone.Level)
+	}
+
+	explicitRows := GraphOptions{Level: ValidateRows, LevelSet: true}.withDefaults()
+	if explicitRows.Level != ValidateRows {
+		t.Errorf("expected an explicit ValidateRows to survive withDefaults, got %d", explicitRows.Level)
+	}
+}